@@ -2,12 +2,12 @@ package prometheus
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"os/user"
 	"path/filepath"
 	"time"
@@ -17,6 +17,7 @@ import (
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -74,6 +75,24 @@ func (p *Prometheus) startK8s(ctx context.Context) error {
 		}
 	}
 
+	var kubeletClient *http.Client
+	var kubeletURL string
+	var nodeName string
+	var preferNodeInformer bool
+	if p.isNodeScrapeScope {
+		kubeletClient, kubeletURL, err = p.newKubeletClient(config)
+		if err != nil {
+			return fmt.Errorf("failed to build kubelet client: %w", err)
+		}
+
+		nodeName = p.nodeName()
+		preferNodeInformer = p.canListNodeLocalPods(ctx, client, nodeName)
+	}
+
+	if p.UseScrapeScheduler {
+		p.startScrapeScheduler(ctx)
+	}
+
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
@@ -83,13 +102,29 @@ func (p *Prometheus) startK8s(ctx context.Context) error {
 				return
 			case <-time.After(time.Second):
 				if p.isNodeScrapeScope {
-					err = p.cAdvisor(ctx, config.BearerToken)
+					if preferNodeInformer {
+						err = p.watchNodeLocalPods(ctx, client, nodeName)
+						if err != nil {
+							p.Log.Errorf("Unable to watch node-local pods, falling back to kubelet /pods polling: %s", err.Error())
+							preferNodeInformer = false
+						}
+					} else {
+						err = p.cAdvisor(ctx, kubeletClient, kubeletURL)
+						if err != nil {
+							p.Log.Errorf("Unable to monitor pods with node scrape scope: %s", err.Error())
+						}
+					}
+				} else if p.LeaderElection {
+					err = p.runWithLeaderElection(ctx, client, func(leaderCtx context.Context) {
+						if runErr := p.runClusterScopeDiscovery(leaderCtx, client, config); runErr != nil {
+							p.Log.Errorf("Unable to watch resources: %s", runErr.Error())
+						}
+					})
 					if err != nil {
-						p.Log.Errorf("Unable to monitor pods with node scrape scope: %s", err.Error())
+						p.Log.Errorf("Unable to run leader election: %s", err.Error())
 					}
 				} else {
-					p.Log.Debugf("start to watch pod in cluster mode")
-					err = p.watchPodFromInformer(ctx, client)
+					err = p.runClusterScopeDiscovery(ctx, client, config)
 					if err != nil {
 						p.Log.Errorf("Unable to watch resources: %s", err.Error())
 					}
@@ -101,6 +136,27 @@ func (p *Prometheus) startK8s(ctx context.Context) error {
 	return nil
 }
 
+// runClusterScopeDiscovery picks the configured cluster-scope discovery
+// mechanism: prometheus-operator CRDs, Endpoints-based discovery, or the
+// legacy annotation-gated pod watch, in that preference order.
+func (p *Prometheus) runClusterScopeDiscovery(ctx context.Context, client *kubernetes.Clientset, config *rest.Config) error {
+	switch {
+	case p.KubernetesServiceDiscovery == "prometheus-operator":
+		p.Log.Debugf("start to watch PodMonitor/ServiceMonitor CRDs in cluster mode")
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("unable to build dynamic client for prometheus-operator CRDs: %w", err)
+		}
+		return p.watchOperatorCRDs(ctx, client, dynamicClient)
+	case p.MonitorKubernetesEndpoints:
+		p.Log.Debugf("start to watch endpoints in cluster mode")
+		return p.watchEndpointsOrSlices(ctx, client)
+	default:
+		p.Log.Debugf("start to watch pod in cluster mode")
+		return p.watchPodFromInformer(ctx, client)
+	}
+}
+
 // An edge case exists if a pod goes offline at the same time a new pod is created
 // (without the scrape annotations). K8s may re-assign the old pod ip to the non-scrape
 // pod, causing errors in the logs. This is only true if the pod going offline is not
@@ -126,11 +182,10 @@ func (p *Prometheus) watchPodFromInformer(ctx context.Context, client *kubernete
 				return
 			}
 
-			if pod.Annotations["prometheus.io/scrape"] != "true" {
-				p.Log.Debug("%s/%s not found prometheus scrape annotations, skip UpdateFunc", pod.Namespace, pod.Name)
-				return
-			}
-
+			// Whether this pod is actually scraped (via the
+			// prometheus.io/scrape annotation or relabel_config) is decided
+			// by relabelDiscoveryTags in registerPod, not here, so that
+			// relabel_config alone can opt a pod in.
 			if !podReady(pod.Status.ContainerStatuses) {
 				p.Log.Debugf("%s/%s not ready,skip UpdateFunc", pod.Namespace, pod.Name)
 				return
@@ -145,11 +200,6 @@ func (p *Prometheus) watchPodFromInformer(ctx context.Context, client *kubernete
 				return
 			}
 
-			if pod.Annotations["prometheus.io/scrape"] != "true" {
-				p.Log.Debug("%s/%s not found prometheus scrape annotations, skip AddFunc", pod.Namespace, pod.Name)
-				return
-			}
-
 			if !podReady(pod.Status.ContainerStatuses) {
 				p.Log.Debugf("%s/%s not ready,skip AddFunc", pod.Namespace, pod.Name)
 				return
@@ -164,11 +214,6 @@ func (p *Prometheus) watchPodFromInformer(ctx context.Context, client *kubernete
 				return
 			}
 
-			if pod.Annotations["prometheus.io/scrape"] != "true" {
-				p.Log.Debug("%s/%s not found prometheus scrape annotations, skip DeleteFunc", pod.Namespace, pod.Name)
-				return
-			}
-
 			unregisterPod(pod, p)
 		},
 	})
@@ -267,18 +312,62 @@ func (p *Prometheus) watchPod(ctx context.Context, client *kubernetes.Clientset)
 	}
 }
 
-func (p *Prometheus) cAdvisor(ctx context.Context, bearerToken string) error {
+// newKubeletClient builds the kubelet's *http.Client once, with its TLS and
+// bearer-token auth handled by rest.TransportFor rather than by mutating
+// http.DefaultTransport (which broke TLS verification for the rest of the
+// process) or reading the token a single time (which stopped working once a
+// projected service-account token rotated past its hour-long expiry).
+func (p *Prometheus) newKubeletClient(inClusterConfig *rest.Config) (*http.Client, string, error) {
+	kubeletURL := p.KubeletURL
+	if kubeletURL == "" {
+		kubeletURL = fmt.Sprintf("https://%s:10250", p.NodeIP)
+	}
+
+	kubeletConfig := &rest.Config{
+		Host: kubeletURL,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure: p.KubeletTLSInsecureSkipVerify,
+			CAFile:   p.KubeletTLSCA,
+		},
+	}
+
+	tokenFile := p.KubeletBearerTokenFile
+	if tokenFile == "" {
+		tokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+	if _, err := os.Stat(tokenFile); err == nil {
+		// rest.TransportFor wraps the round tripper with a bearer-auth
+		// transport that periodically re-reads this file, so rotated
+		// projected service-account tokens keep working without a restart.
+		kubeletConfig.BearerTokenFile = tokenFile
+	} else if inClusterConfig != nil {
+		kubeletConfig.BearerToken = inClusterConfig.BearerToken
+	}
+
+	if kubeletConfig.TLSClientConfig.CAFile == "" && !p.KubeletTLSInsecureSkipVerify && inClusterConfig != nil {
+		kubeletConfig.TLSClientConfig.CAFile = inClusterConfig.TLSClientConfig.CAFile
+		kubeletConfig.TLSClientConfig.CAData = inClusterConfig.TLSClientConfig.CAData
+	}
+
+	rt, err := rest.TransportFor(kubeletConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not build kubelet transport: %w", err)
+	}
+
+	return &http.Client{Transport: rt}, kubeletURL, nil
+}
+
+func (p *Prometheus) cAdvisor(ctx context.Context, httpClient *http.Client, kubeletURL string) error {
 	// The request will be the same each time
-	podsURL := fmt.Sprintf("https://%s:10250/pods", p.NodeIP)
+	podsURL := kubeletURL + "/pods"
 	req, err := http.NewRequest("GET", podsURL, nil)
 	if err != nil {
 		return fmt.Errorf("error when creating request to %s to get pod list: %w", podsURL, err)
 	}
-	req.Header.Set("Authorization", "Bearer "+bearerToken)
 	req.Header.Add("Accept", "application/json")
 
 	// Update right away so code is not waiting the length of the specified scrape interval initially
-	err = updateCadvisorPodList(p, req)
+	err = updateCadvisorPodList(p, httpClient, req)
 	if err != nil {
 		return fmt.Errorf("error initially updating pod list: %w", err)
 	}
@@ -293,7 +382,7 @@ func (p *Prometheus) cAdvisor(ctx context.Context, bearerToken string) error {
 		case <-ctx.Done():
 			return nil
 		case <-time.After(time.Duration(scrapeInterval) * time.Second):
-			err := updateCadvisorPodList(p, req)
+			err := updateCadvisorPodList(p, httpClient, req)
 			if err != nil {
 				return fmt.Errorf("error updating pod list: %w", err)
 			}
@@ -301,10 +390,7 @@ func (p *Prometheus) cAdvisor(ctx context.Context, bearerToken string) error {
 	}
 }
 
-func updateCadvisorPodList(p *Prometheus, req *http.Request) error {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	httpClient := http.Client{}
-
+func updateCadvisorPodList(p *Prometheus, httpClient *http.Client, req *http.Request) error {
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("error when making request for pod list: %w", err)
@@ -326,11 +412,11 @@ func updateCadvisorPodList(p *Prometheus, req *http.Request) error {
 	}
 	pods := cadvisorPodsResponse.Items
 
-	// Register pod only if it has an annotation to scrape, if it is ready,
-	// and if namespace and selectors are specified and match
+	// Register pod only if it is ready and if namespace and selectors are
+	// specified and match; whether it's actually scraped is then decided by
+	// relabelDiscoveryTags inside registerPod.
 	for _, pod := range pods {
 		if necessaryPodFieldsArePresent(pod) &&
-			pod.Annotations["prometheus.io/scrape"] == "true" &&
 			podReady(pod.Status.ContainerStatuses) &&
 			podHasMatchingNamespace(pod, p) &&
 			podHasMatchingLabelSelector(pod, p.podLabelSelector) &&
@@ -437,6 +523,14 @@ func registerPod(pod *corev1.Pod, p *Prometheus) {
 	for k, v := range pod.Labels {
 		tags[k] = v
 	}
+
+	tags, keep := p.relabelDiscoveryTags(tags)
+	if !keep {
+		p.Log.Debugf("relabel_config dropped pod %s, removing from scrape targets", key)
+		delete(p.kubernetesPods, key)
+		return
+	}
+
 	podURL := p.AddressToURL(targetURL, targetURL.Hostname())
 
 	p.kubernetesPods[key] = URLAndAddress{
@@ -0,0 +1,419 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// watchEndpointsFromInformer mirrors Prometheus's `role: endpoints` service
+// discovery: it watches Endpoints (and, when enabled, EndpointSlice) objects
+// instead of Pods directly, and resolves each ready address back to its pod
+// through a parallel Pod informer keyed by UID. This produces one scrape
+// target per port per ready address, tagged with the owning service's name,
+// namespace and labels in addition to the pod tags registerPod already adds.
+func (p *Prometheus) watchEndpointsFromInformer(ctx context.Context, client *kubernetes.Clientset) error {
+	optionsModifier := func(options *metav1.ListOptions) {
+		options.FieldSelector = p.KubernetesFieldSelector
+		options.LabelSelector = p.KubernetesLabelSelector
+	}
+
+	podInformer := cache.NewSharedIndexInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "pods", p.PodNamespace, func(options *metav1.ListOptions) {
+			options.FieldSelector = p.KubernetesFieldSelector
+		}),
+		&corev1.Pod{}, time.Minute*15, cache.Indexers{podUIDIndex: podUIDIndexFunc},
+	)
+
+	endpointsInformer := cache.NewSharedInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "endpoints", p.PodNamespace, optionsModifier),
+		&corev1.Endpoints{}, time.Minute*15,
+	)
+
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "endpoints")
+
+	endpointsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.enqueueEndpoints(queue, obj) },
+		UpdateFunc: func(_, newObj interface{}) { p.enqueueEndpoints(queue, newObj) },
+		DeleteFunc: func(obj interface{}) {
+			endpoints, ok := obj.(*corev1.Endpoints)
+			if !ok {
+				p.Log.Warnf("expect type endpoints")
+				return
+			}
+			unregisterEndpoints(endpoints, p)
+		},
+	})
+
+	// Pod add/update/delete don't change the endpoint set themselves, but they
+	// do change the information (IP, readiness, labels) an already-registered
+	// endpoint depends on, so re-resolve the owning Endpoints object whenever a
+	// backing pod changes.
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.enqueueOwningEndpoints(queue, endpointsInformer, obj) },
+		UpdateFunc: func(_, newObj interface{}) { p.enqueueOwningEndpoints(queue, endpointsInformer, newObj) },
+		DeleteFunc: func(obj interface{}) { p.enqueueOwningEndpoints(queue, endpointsInformer, obj) },
+	})
+
+	go podInformer.Run(ctx.Done())
+	go endpointsInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced, endpointsInformer.HasSynced) {
+		return fmt.Errorf("failed to sync endpoints informer cache")
+	}
+
+	go func() {
+		for {
+			item, shutdown := queue.Get()
+			if shutdown {
+				p.Log.Infof("endpoints informer shutdown")
+				return
+			}
+
+			key := item.(string)
+			queue.Done(item)
+
+			obj, exist, err := endpointsInformer.GetStore().GetByKey(key)
+			if err != nil {
+				p.Log.Errorf("get endpoints %s from cache err: %v", key, err)
+				continue
+			}
+			if !exist {
+				continue
+			}
+
+			p.registerEndpoints(obj.(*corev1.Endpoints), podInformer)
+		}
+	}()
+
+	<-ctx.Done()
+	p.Log.Infof("context close, shutdown endpoints queue")
+	queue.ShutDown()
+	return nil
+}
+
+const podUIDIndex = "podUID"
+
+func podUIDIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	return []string{string(pod.UID)}, nil
+}
+
+func (p *Prometheus) enqueueEndpoints(queue workqueue.RateLimitingInterface, obj interface{}) {
+	endpoints, ok := obj.(*corev1.Endpoints)
+	if !ok {
+		p.Log.Warnf("expect type endpoints")
+		return
+	}
+	queue.Add(fmt.Sprintf("%s/%s", endpoints.Namespace, endpoints.Name))
+}
+
+// enqueueOwningEndpoints re-queues the Endpoints object that targets the pod
+// referenced by obj, identified by matching the pod's UID against each
+// subset address's TargetRef within the pod's namespace.
+func (p *Prometheus) enqueueOwningEndpoints(queue workqueue.RateLimitingInterface, endpointsInformer cache.SharedInformer, obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	for _, item := range endpointsInformer.GetStore().List() {
+		endpoints, ok := item.(*corev1.Endpoints)
+		if !ok || endpoints.Namespace != pod.Namespace {
+			continue
+		}
+		if endpointsReferencesPod(endpoints, pod) {
+			queue.Add(fmt.Sprintf("%s/%s", endpoints.Namespace, endpoints.Name))
+		}
+	}
+}
+
+func endpointsReferencesPod(endpoints *corev1.Endpoints, pod *corev1.Pod) bool {
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range append(append([]corev1.EndpointAddress{}, subset.Addresses...), subset.NotReadyAddresses...) {
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" && addr.TargetRef.UID == pod.UID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// registerEndpoints resolves every ready address in endpoints to its backing
+// pod (via the UID recorded in TargetRef, not IP, to avoid racing on pod IP
+// reuse) and registers one scrape target per port, then reconciles away any
+// previously registered target whose address no longer appears in the live
+// object at all (e.g. a scale-down), not just ones that moved to
+// NotReadyAddresses.
+func (p *Prometheus) registerEndpoints(endpoints *corev1.Endpoints, podInformer cache.SharedIndexInformer) {
+	seen := map[string]bool{}
+
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.TargetRef == nil || addr.TargetRef.Kind != "Pod" {
+				continue
+			}
+
+			pod := lookupPodByUID(podInformer, string(addr.TargetRef.UID))
+			if pod == nil {
+				p.Log.Debugf("endpoints %s/%s: backing pod %s not found in cache, skipping", endpoints.Namespace, endpoints.Name, addr.TargetRef.Name)
+				continue
+			}
+
+			if !podReady(pod.Status.ContainerStatuses) {
+				continue
+			}
+
+			for _, port := range subset.Ports {
+				seen[registerEndpointTarget(p, endpoints, endpoints.Name, pod, addr, port)] = true
+			}
+		}
+	}
+
+	p.reconcileEndpointTargets(endpoints.Namespace, endpoints.Name, seen)
+}
+
+func lookupPodByUID(podInformer cache.SharedIndexInformer, uid string) *corev1.Pod {
+	objs, err := podInformer.GetIndexer().ByIndex(podUIDIndex, uid)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+	pod, _ := objs[0].(*corev1.Pod)
+	return pod
+}
+
+// registerEndpointTarget registers one scrape target and always returns its
+// key, whether or not registration actually succeeded, so callers can track
+// every address/port they attempted this round and reconcile away the ones
+// they didn't (see reconcileEndpointTargets). serviceName is tagged
+// separately from endpoints.Name/Namespace because the two differ for
+// EndpointSlice: endpoints here is a synthetic object identified by the
+// slice's own (generated) name, while serviceName is the actual owning
+// Service.
+func registerEndpointTarget(p *Prometheus, endpoints *corev1.Endpoints, serviceName string, pod *corev1.Pod, addr corev1.EndpointAddress, port corev1.EndpointPort) string {
+	key := fmt.Sprintf("%s/%s/%s/%s", endpoints.Namespace, endpoints.Name, addr.IP, port.Name)
+
+	targetURL, err := buildEndpointScrapeURL(pod, addr.IP, port)
+	if err != nil {
+		p.Log.Errorf("could not build endpoint scrape URL for %s/%s: %s", endpoints.Namespace, endpoints.Name, err)
+		return key
+	}
+
+	tags := map[string]string{
+		"pod_name":      pod.Name,
+		"pod_namespace": pod.Namespace,
+		"service_name":  serviceName,
+		"service_port":  port.Name,
+		"namespace":     endpoints.Namespace,
+	}
+	for k, v := range pod.Annotations {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	for k, v := range endpoints.Labels {
+		tags["service_label_"+k] = v
+	}
+	for k, v := range pod.Labels {
+		tags[k] = v
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	tags, keep := p.relabelDiscoveryTags(tags)
+	if !keep {
+		delete(p.kubernetesPods, key)
+		return key
+	}
+
+	if p.kubernetesPods == nil {
+		p.kubernetesPods = map[string]URLAndAddress{}
+	}
+	p.kubernetesPods[key] = URLAndAddress{
+		URL:         p.AddressToURL(targetURL, targetURL.Hostname()),
+		Address:     targetURL.Hostname(),
+		OriginalURL: targetURL,
+		Tags:        tags,
+	}
+	return key
+}
+
+func buildEndpointScrapeURL(pod *corev1.Pod, ip string, port corev1.EndpointPort) (*url.URL, error) {
+	scheme := pod.Annotations["prometheus.io/scheme"]
+	path := pod.Annotations["prometheus.io/path"]
+	if scheme == "" {
+		scheme = "http"
+	}
+	if path == "" {
+		path = "/metrics"
+	}
+
+	base, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	base.Scheme = scheme
+	base.Host = net.JoinHostPort(ip, fmt.Sprintf("%d", port.Port))
+	return base, nil
+}
+
+// reconcileEndpointTargets removes every previously registered target keyed
+// under namespace/name that wasn't in this round's seen set. This is what
+// actually closes the pod-IP-reuse race the endpoints/EndpointSlice
+// discovery exists to avoid: an address that simply stops appearing in the
+// live object (e.g. on scale-down) is cleaned up immediately instead of
+// lingering until the whole object is deleted, so a freed IP reused by an
+// unrelated pod can't keep being reported under the old pod's stale tags.
+func (p *Prometheus) reconcileEndpointTargets(namespace, name string, seen map[string]bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	prefix := fmt.Sprintf("%s/%s/", namespace, name)
+	for key := range p.kubernetesPods {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix && !seen[key] {
+			delete(p.kubernetesPods, key)
+		}
+	}
+}
+
+func unregisterEndpoints(endpoints *corev1.Endpoints, p *Prometheus) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	prefix := fmt.Sprintf("%s/%s/", endpoints.Namespace, endpoints.Name)
+	for key := range p.kubernetesPods {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			delete(p.kubernetesPods, key)
+		}
+	}
+}
+
+// watchEndpointsOrSlices prefers the discoveryv1.EndpointSlice informer over
+// the plain Endpoints informer when the cluster actually serves the
+// discovery.k8s.io/v1 API, since large Services are split into slices there
+// and the singular Endpoints object is capped at 1000 addresses. Clusters
+// too old to have EndpointSlice enabled fall back to watchEndpointsFromInformer.
+func (p *Prometheus) watchEndpointsOrSlices(ctx context.Context, client *kubernetes.Clientset) error {
+	if p.endpointSlicesAvailable(client) {
+		p.Log.Debugf("discovery.k8s.io/v1 EndpointSlice available, using slice-based endpoint discovery")
+		return p.watchEndpointSlicesFromInformer(ctx, client)
+	}
+	return p.watchEndpointsFromInformer(ctx, client)
+}
+
+// endpointSlicesAvailable probes the API server's served resources for
+// discovery.k8s.io/v1 rather than assuming it based on server version, since
+// EndpointSlice can be disabled independently of the Kubernetes minor version.
+func (p *Prometheus) endpointSlicesAvailable(client *kubernetes.Clientset) bool {
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(discoveryv1.SchemeGroupVersion.String())
+	if err != nil {
+		return false
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Kind == "EndpointSlice" {
+			return true
+		}
+	}
+	return false
+}
+
+// watchEndpointSlicesFromInformer is the discoveryv1.EndpointSlice analogue
+// of watchEndpointsFromInformer, used when the cluster has split large
+// Endpoints objects into slices. It shares the same Pod-by-UID resolution and
+// target registration path.
+func (p *Prometheus) watchEndpointSlicesFromInformer(ctx context.Context, client *kubernetes.Clientset) error {
+	podInformer := cache.NewSharedIndexInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "pods", p.PodNamespace, func(options *metav1.ListOptions) {
+			options.FieldSelector = p.KubernetesFieldSelector
+		}),
+		&corev1.Pod{}, time.Minute*15, cache.Indexers{podUIDIndex: podUIDIndexFunc},
+	)
+
+	sliceInformer := cache.NewSharedInformer(
+		cache.NewFilteredListWatchFromClient(client.DiscoveryV1().RESTClient(), "endpointslices", p.PodNamespace, func(options *metav1.ListOptions) {
+			options.LabelSelector = p.KubernetesLabelSelector
+		}),
+		&discoveryv1.EndpointSlice{}, time.Minute*15,
+	)
+
+	go podInformer.Run(ctx.Done())
+	go sliceInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced, sliceInformer.HasSynced) {
+		return fmt.Errorf("failed to sync endpointslice informer cache")
+	}
+
+	sliceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.registerEndpointSlice(obj, podInformer) },
+		UpdateFunc: func(_, newObj interface{}) { p.registerEndpointSlice(newObj, podInformer) },
+		DeleteFunc: func(obj interface{}) { p.unregisterEndpointSlice(obj) },
+	})
+
+	<-ctx.Done()
+	return nil
+}
+
+func (p *Prometheus) registerEndpointSlice(obj interface{}, podInformer cache.SharedIndexInformer) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+
+	// An EndpointSlice's own Name is a generated per-slice identifier
+	// (e.g. "myservice-7xk2p"), not the owning Service's name; that's
+	// recorded separately in the well-known kubernetes.io/service-name
+	// label.
+	serviceName := slice.Labels[discoveryv1.LabelServiceName]
+	if serviceName == "" {
+		serviceName = slice.Name
+	}
+
+	syntheticEndpoints := &corev1.Endpoints{ObjectMeta: slice.ObjectMeta}
+	seen := map[string]bool{}
+
+	for _, endpoint := range slice.Endpoints {
+		if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" || len(endpoint.Addresses) == 0 {
+			continue
+		}
+		if endpoint.Conditions.Ready == nil || !*endpoint.Conditions.Ready {
+			continue
+		}
+
+		pod := lookupPodByUID(podInformer, string(endpoint.TargetRef.UID))
+		if pod == nil {
+			continue
+		}
+
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+			name := ""
+			if port.Name != nil {
+				name = *port.Name
+			}
+			seen[registerEndpointTarget(p, syntheticEndpoints, serviceName, pod, corev1.EndpointAddress{IP: endpoint.Addresses[0]}, corev1.EndpointPort{Name: name, Port: *port.Port})] = true
+		}
+	}
+
+	p.reconcileEndpointTargets(slice.Namespace, slice.Name, seen)
+}
+
+func (p *Prometheus) unregisterEndpointSlice(obj interface{}) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+	unregisterEndpoints(&corev1.Endpoints{ObjectMeta: slice.ObjectMeta}, p)
+}
@@ -0,0 +1,129 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/influxdata/telegraf/config"
+)
+
+const (
+	defaultLeaseDuration = config.Duration(15 * time.Second)
+	defaultRenewDeadline = config.Duration(10 * time.Second)
+	defaultRetryPeriod   = config.Duration(2 * time.Second)
+)
+
+// runWithLeaderElection wraps a Telegraf DaemonSet's cluster-scope discovery
+// in a client-go Lease so that only the elected replica runs `run` and
+// produces kubernetesPods entries. Standbys run no discovery at all: `run`,
+// and with it every informer it starts, only begins once the lease is
+// acquired. This avoids every replica scraping (and therefore duplicating)
+// the same cluster-wide target set.
+func (p *Prometheus) runWithLeaderElection(ctx context.Context, client *kubernetes.Clientset, run func(context.Context)) error {
+	identity, err := p.leaderElectionIdentity()
+	if err != nil {
+		return fmt.Errorf("could not determine leader election identity: %w", err)
+	}
+
+	leaseName := p.LeaderElectionLeaseName
+	if leaseName == "" {
+		leaseName = "telegraf-prometheus-input"
+	}
+	leaseNamespace := p.LeaderElectionLeaseNamespace
+	if leaseNamespace == "" {
+		leaseNamespace = "default"
+	}
+
+	leaseDuration := p.LeaderElectionLeaseDuration
+	if leaseDuration == 0 {
+		leaseDuration = defaultLeaseDuration
+	}
+	renewDeadline := p.RenewDeadline
+	if renewDeadline == 0 {
+		renewDeadline = defaultRenewDeadline
+	}
+	retryPeriod := p.RetryPeriod
+	if retryPeriod == 0 {
+		retryPeriod = defaultRetryPeriod
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: leaseNamespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   time.Duration(leaseDuration),
+		RenewDeadline:   time.Duration(renewDeadline),
+		RetryPeriod:     time.Duration(retryPeriod),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				p.Log.Infof("%s acquired leader election lease %s/%s, starting cluster-scope discovery", identity, leaseNamespace, leaseName)
+				p.setLeading(true)
+				run(leaderCtx)
+			},
+			OnStoppedLeading: func() {
+				p.Log.Infof("%s lost leader election lease %s/%s, draining scrape targets", identity, leaseNamespace, leaseName)
+				p.setLeading(false)
+				p.drainKubernetesPods()
+			},
+			OnNewLeader: func(newLeader string) {
+				if newLeader != identity {
+					p.Log.Debugf("leader election lease %s/%s is held by %s", leaseNamespace, leaseName, newLeader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		// A bad lease-duration/renew-deadline/retry-period combination is a
+		// config error, not a reason to crash the whole Telegraf process the
+		// way leaderelection.RunOrDie would.
+		return fmt.Errorf("could not build leader elector: %w", err)
+	}
+
+	elector.Run(ctx)
+	return nil
+}
+
+// leaderElectionIdentity derives a unique per-pod identity for the lease
+// holder identity field, preferring the downward-API POD_NAME so that
+// restarts of the same pod re-acquire cleanly.
+func (p *Prometheus) leaderElectionIdentity() (string, error) {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName, nil
+	}
+	return os.Hostname()
+}
+
+// setLeading records leadership state as an internal metric so operators can
+// alert if no replica (or more than one, under a split-brain) believes it is
+// leading.
+func (p *Prometheus) setLeading(leading bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.isLeading = leading
+}
+
+// drainKubernetesPods clears every target this plugin instance has
+// registered. Called when leadership is lost so a new leader starts from an
+// empty set instead of racing the old leader's stale entries.
+func (p *Prometheus) drainKubernetesPods() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.kubernetesPods = map[string]URLAndAddress{}
+}
@@ -0,0 +1,124 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// nodeName returns the node this Telegraf instance is running on, preferring
+// the node_name config override and falling back to the downward-API
+// NODE_NAME env var that DaemonSet manifests conventionally set.
+func (p *Prometheus) nodeName() string {
+	if p.NodeName != "" {
+		return p.NodeName
+	}
+	return os.Getenv("NODE_NAME")
+}
+
+// canListNodeLocalPods probes whether the API server is reachable and RBAC
+// allows listing pods field-selected to this node, so startK8s can decide
+// between the event-driven informer and the kubelet /pods poll fallback
+// without blocking in the hot path on every tick.
+func (p *Prometheus) canListNodeLocalPods(ctx context.Context, client *kubernetes.Clientset, nodeName string) bool {
+	if nodeName == "" {
+		return false
+	}
+
+	_, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+		Limit:         1,
+	})
+	if err != nil {
+		p.Log.Warnf("node-local pod informer unavailable (%s), falling back to kubelet /pods polling", err)
+		return false
+	}
+	return true
+}
+
+// watchNodeLocalPods mirrors watchPodFromInformer but field-selects on
+// spec.nodeName instead of watching cluster-wide, giving node-scoped
+// DaemonSets sub-second reaction to pod churn instead of waiting up to
+// PodScrapeInterval for the next kubelet /pods poll.
+func (p *Prometheus) watchNodeLocalPods(ctx context.Context, client *kubernetes.Clientset, nodeName string) error {
+	optionsModifier := func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		options.LabelSelector = p.KubernetesLabelSelector
+	}
+
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "node-local-pods")
+
+	podInformer := cache.NewSharedInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "pods", p.PodNamespace, optionsModifier),
+		&corev1.Pod{}, time.Minute*15,
+	)
+
+	enqueue := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			p.Log.Warnf("expect type pod")
+			return
+		}
+		// Whether this pod is actually scraped is decided by
+		// relabelDiscoveryTags in registerPod, not here, so that
+		// relabel_config alone can opt a pod in.
+		if !podReady(pod.Status.ContainerStatuses) {
+			return
+		}
+		queue.Add(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+	}
+
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, newObj interface{}) { enqueue(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			pod, ok := obj.(*corev1.Pod)
+			if !ok {
+				p.Log.Warnf("expect type pod")
+				return
+			}
+			unregisterPod(pod, p)
+		},
+	})
+
+	go podInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
+		return fmt.Errorf("failed to sync node-local pod informer cache")
+	}
+
+	go func() {
+		for {
+			item, shutdown := queue.Get()
+			if shutdown {
+				return
+			}
+			queue.Done(item)
+
+			key := item.(string)
+			obj, exist, err := podInformer.GetStore().GetByKey(key)
+			if err != nil {
+				p.Log.Errorf("get %s from cache err: %v", key, err)
+				continue
+			}
+			if !exist {
+				continue
+			}
+
+			registerPod(obj.(*corev1.Pod), p)
+		}
+	}()
+
+	<-ctx.Done()
+	p.Log.Infof("context close, shutdown node-local pod queue")
+	queue.ShutDown()
+	return nil
+}
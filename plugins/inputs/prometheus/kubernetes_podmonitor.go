@@ -0,0 +1,477 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	podMonitorGVR = schema.GroupVersionResource{
+		Group: "monitoring.coreos.com", Version: "v1", Resource: "podmonitors",
+	}
+	serviceMonitorGVR = schema.GroupVersionResource{
+		Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors",
+	}
+)
+
+// monitorEndpoint is the subset of a prometheus-operator Endpoint (shared by
+// PodMonitor's podMetricsEndpoints and ServiceMonitor's endpoints) that this
+// plugin knows how to turn into a scrape target.
+type monitorEndpoint struct {
+	Port                 string                   `json:"port"`
+	TargetPort           intOrStringJSON          `json:"targetPort"`
+	Path                 string                   `json:"path"`
+	Scheme               string                   `json:"scheme"`
+	Interval             string                   `json:"interval"`
+	ScrapeTimeout        string                   `json:"scrapeTimeout"`
+	RelabelConfigs       []map[string]interface{} `json:"relabelings"`
+	MetricRelabelConfigs []map[string]interface{} `json:"metricRelabelings"`
+}
+
+// intOrStringJSON accepts either a named port (string) or a numeric container
+// port, matching Kubernetes' IntOrString wire format.
+type intOrStringJSON struct {
+	strVal string
+	intVal int32
+}
+
+func (v *intOrStringJSON) UnmarshalJSON(data []byte) error {
+	var asInt int32
+	if err := json.Unmarshal(data, &asInt); err == nil {
+		v.intVal = asInt
+		return nil
+	}
+	return json.Unmarshal(data, &v.strVal)
+}
+
+type monitorNamespaceSelector struct {
+	Any        bool     `json:"any"`
+	MatchNames []string `json:"matchNames"`
+}
+
+type podMonitorSpec struct {
+	JobLabel            string                   `json:"jobLabel"`
+	PodMetricsEndpoints []monitorEndpoint        `json:"podMetricsEndpoints"`
+	NamespaceSelector   monitorNamespaceSelector `json:"namespaceSelector"`
+	Selector            metav1.LabelSelector     `json:"selector"`
+}
+
+type serviceMonitorSpec struct {
+	JobLabel          string                   `json:"jobLabel"`
+	Endpoints         []monitorEndpoint        `json:"endpoints"`
+	NamespaceSelector monitorNamespaceSelector `json:"namespaceSelector"`
+	Selector          metav1.LabelSelector     `json:"selector"`
+}
+
+// watchOperatorCRDs watches monitoring.coreos.com/v1 PodMonitor and
+// ServiceMonitor custom resources with a dynamic informer and joins each one
+// against the Pod cache to produce scrape targets, so a cluster already
+// configured for prometheus-operator doesn't need its scrape config
+// duplicated in telegraf.conf.
+func (p *Prometheus) watchOperatorCRDs(ctx context.Context, client *kubernetes.Clientset, dynamicClient dynamic.Interface) error {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, time.Minute*15)
+
+	podMonitorInformer := factory.ForResource(podMonitorGVR).Informer()
+	serviceMonitorInformer := factory.ForResource(serviceMonitorGVR).Informer()
+
+	podInformer := cache.NewSharedIndexInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "pods", metav1.NamespaceAll, func(*metav1.ListOptions) {}),
+		&corev1.Pod{}, time.Minute*15, cache.Indexers{podUIDIndex: podUIDIndexFunc},
+	)
+
+	// serviceInformer and endpointsInformer let addServiceMonitorTargets join
+	// a ServiceMonitor's selector against Service objects (the kind its
+	// selector actually targets per prometheus-operator semantics) and then
+	// resolve scrape targets through the Service's own Endpoints, instead of
+	// matching the selector against pod labels directly.
+	serviceInformer := cache.NewSharedInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "services", metav1.NamespaceAll, func(*metav1.ListOptions) {}),
+		&corev1.Service{}, time.Minute*15,
+	)
+
+	endpointsInformer := cache.NewSharedInformer(
+		cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "endpoints", metav1.NamespaceAll, func(*metav1.ListOptions) {}),
+		&corev1.Endpoints{}, time.Minute*15,
+	)
+
+	rebuild := func() {
+		p.rebuildOperatorTargets(podMonitorInformer, serviceMonitorInformer, podInformer, serviceInformer, endpointsInformer)
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { rebuild() },
+		UpdateFunc: func(interface{}, interface{}) { rebuild() },
+		DeleteFunc: func(interface{}) { rebuild() },
+	}
+	podMonitorInformer.AddEventHandler(handler)
+	serviceMonitorInformer.AddEventHandler(handler)
+	podInformer.AddEventHandler(handler)
+	serviceInformer.AddEventHandler(handler)
+	endpointsInformer.AddEventHandler(handler)
+
+	go podMonitorInformer.Run(ctx.Done())
+	go serviceMonitorInformer.Run(ctx.Done())
+	go podInformer.Run(ctx.Done())
+	go serviceInformer.Run(ctx.Done())
+	go endpointsInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), podMonitorInformer.HasSynced, serviceMonitorInformer.HasSynced,
+		podInformer.HasSynced, serviceInformer.HasSynced, endpointsInformer.HasSynced) {
+		return fmt.Errorf("failed to sync prometheus-operator CRD informer cache")
+	}
+
+	rebuild()
+
+	<-ctx.Done()
+	return nil
+}
+
+// rebuildOperatorTargets recomputes the full set of CRD-derived scrape
+// targets and swaps them into p.kubernetesPods, replacing whatever the
+// previous PodMonitor/ServiceMonitor generation produced. CRD churn is rare
+// compared to pod churn, so a full rebuild on every informer event is simpler
+// than incremental add/remove bookkeeping and cheap enough in practice.
+func (p *Prometheus) rebuildOperatorTargets(podMonitorInformer, serviceMonitorInformer cache.SharedInformer, podInformer cache.SharedIndexInformer, serviceInformer, endpointsInformer cache.SharedInformer) {
+	pods := make([]*corev1.Pod, 0)
+	for _, obj := range podInformer.GetStore().List() {
+		if pod, ok := obj.(*corev1.Pod); ok && podReady(pod.Status.ContainerStatuses) {
+			pods = append(pods, pod)
+		}
+	}
+
+	services := make([]*corev1.Service, 0)
+	for _, obj := range serviceInformer.GetStore().List() {
+		if svc, ok := obj.(*corev1.Service); ok {
+			services = append(services, svc)
+		}
+	}
+
+	endpointsByName := make(map[string]*corev1.Endpoints, len(services))
+	for _, obj := range endpointsInformer.GetStore().List() {
+		if ep, ok := obj.(*corev1.Endpoints); ok {
+			endpointsByName[fmt.Sprintf("%s/%s", ep.Namespace, ep.Name)] = ep
+		}
+	}
+
+	targets := map[string]URLAndAddress{}
+
+	for _, obj := range podMonitorInformer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		spec, name, err := decodePodMonitor(u)
+		if err != nil {
+			p.Log.Errorf("could not decode PodMonitor %s: %s", u.GetName(), err)
+			continue
+		}
+		p.addPodMonitorTargets(targets, name, spec, pods)
+	}
+
+	for _, obj := range serviceMonitorInformer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		spec, name, err := decodeServiceMonitor(u)
+		if err != nil {
+			p.Log.Errorf("could not decode ServiceMonitor %s: %s", u.GetName(), err)
+			continue
+		}
+		p.addServiceMonitorTargets(targets, name, spec, services, endpointsByName, podInformer)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.kubernetesPods = targets
+}
+
+func decodePodMonitor(u *unstructured.Unstructured) (*podMonitorSpec, string, error) {
+	spec := &podMonitorSpec{}
+	rawSpec, _, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSpec, spec); err != nil {
+		return nil, "", err
+	}
+	return spec, u.GetName(), nil
+}
+
+func decodeServiceMonitor(u *unstructured.Unstructured) (*serviceMonitorSpec, string, error) {
+	spec := &serviceMonitorSpec{}
+	rawSpec, _, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSpec, spec); err != nil {
+		return nil, "", err
+	}
+	return spec, u.GetName(), nil
+}
+
+func (p *Prometheus) addPodMonitorTargets(targets map[string]URLAndAddress, name string, spec *podMonitorSpec, pods []*corev1.Pod) {
+	selector, err := metav1.LabelSelectorAsSelector(&spec.Selector)
+	if err != nil {
+		p.Log.Errorf("PodMonitor %s has an invalid selector: %s", name, err)
+		return
+	}
+
+	for _, pod := range pods {
+		if !namespaceSelected(spec.NamespaceSelector, pod.Namespace) {
+			continue
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		for _, ep := range spec.PodMetricsEndpoints {
+			p.addMonitorTarget(targets, "podmonitor/"+name, pod, pod.Namespace, nil, nil, ep)
+		}
+	}
+}
+
+// addServiceMonitorTargets matches spec.Selector against Service objects, per
+// prometheus-operator's ServiceMonitor semantics, then resolves each matched
+// Service's own Endpoints to find its backing, ready pods - a selector on a
+// ServiceMonitor targets Services, not Pods, and the two only carry the same
+// labels by convention, not by guarantee.
+func (p *Prometheus) addServiceMonitorTargets(targets map[string]URLAndAddress, name string, spec *serviceMonitorSpec, services []*corev1.Service, endpointsByName map[string]*corev1.Endpoints, podInformer cache.SharedIndexInformer) {
+	selector, err := metav1.LabelSelectorAsSelector(&spec.Selector)
+	if err != nil {
+		p.Log.Errorf("ServiceMonitor %s has an invalid selector: %s", name, err)
+		return
+	}
+
+	for _, svc := range services {
+		if !namespaceSelected(spec.NamespaceSelector, svc.Namespace) {
+			continue
+		}
+		if !selector.Matches(labels.Set(svc.Labels)) {
+			continue
+		}
+
+		// Endpoints objects share their owning Service's name and namespace
+		// by Kubernetes convention.
+		endpoints, ok := endpointsByName[fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)]
+		if !ok {
+			continue
+		}
+
+		extraTags := map[string]string{"service_name": svc.Name}
+		for k, v := range svc.Labels {
+			extraTags["service_label_"+k] = v
+		}
+
+		for _, subset := range endpoints.Subsets {
+			for _, addr := range subset.Addresses {
+				if addr.TargetRef == nil || addr.TargetRef.Kind != "Pod" {
+					continue
+				}
+				pod := lookupPodByUID(podInformer, string(addr.TargetRef.UID))
+				if pod == nil || !podReady(pod.Status.ContainerStatuses) {
+					continue
+				}
+				for _, ep := range spec.Endpoints {
+					p.addMonitorTarget(targets, "servicemonitor/"+name, pod, svc.Namespace, extraTags, subset.Ports, ep)
+				}
+			}
+		}
+	}
+}
+
+func namespaceSelected(sel monitorNamespaceSelector, namespace string) bool {
+	if sel.Any || len(sel.MatchNames) == 0 {
+		return true
+	}
+	for _, ns := range sel.MatchNames {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// addMonitorTarget turns one resolved (pod, Endpoint) pair into a scrape
+// target, applying the endpoint's own interval/scrapeTimeout/relabelings/
+// metricRelabelings instead of silently discarding them: interval and
+// scrapeTimeout become prometheus.io/* tags the scrape scheduler already
+// knows how to read (see scrapeInterval/scrapeTimeout), relabelings run through the same relabel engine
+// relabel_config uses (dropping the target if rejected), and
+// metricRelabelings are compiled onto the target so the scrape path applies
+// them in addition to the global metric_relabel_config.
+//
+// ports is the joined Service's subset.Ports when called for a
+// ServiceMonitor (its endpoints[].port names a Service port, not a
+// container port) and nil when called for a PodMonitor, whose
+// endpoints[].port does name a port on the pod directly.
+func (p *Prometheus) addMonitorTarget(targets map[string]URLAndAddress, kind string, pod *corev1.Pod, namespace string, extraTags map[string]string, ports []corev1.EndpointPort, ep monitorEndpoint) {
+	var port string
+	if ports != nil {
+		port = resolveServicePort(ports, ep)
+	} else {
+		port = resolvePodPort(pod, ep)
+	}
+	if port == "" {
+		return
+	}
+
+	scheme := ep.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := ep.Path
+	if path == "" {
+		path = "/metrics"
+	}
+
+	base, err := url.Parse(path)
+	if err != nil {
+		return
+	}
+	base.Scheme = scheme
+	base.Host = net.JoinHostPort(pod.Status.PodIP, port)
+
+	tags := map[string]string{
+		"pod_name":      pod.Name,
+		"pod_namespace": namespace,
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+	for k, v := range pod.Labels {
+		tags[k] = v
+	}
+	if ep.Interval != "" {
+		tags["prometheus.io/interval"] = ep.Interval
+	}
+	if ep.ScrapeTimeout != "" {
+		tags["prometheus.io/scrape_timeout"] = ep.ScrapeTimeout
+	}
+
+	relabelConfigs, err := decodeMonitorRelabelConfigs(ep.RelabelConfigs)
+	if err != nil {
+		p.Log.Errorf("%s: invalid relabelings on %s/%s: %s", kind, namespace, pod.Name, err)
+		return
+	}
+	tags, keep := applyRelabelConfigs(relabelConfigs, tags)
+	if !keep {
+		return
+	}
+
+	metricRelabelConfigs, err := decodeMonitorRelabelConfigs(ep.MetricRelabelConfigs)
+	if err != nil {
+		p.Log.Errorf("%s: invalid metricRelabelings on %s/%s: %s", kind, namespace, pod.Name, err)
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s", kind, namespace, pod.Name, port)
+	targets[key] = URLAndAddress{
+		URL:                  base,
+		Address:              pod.Status.PodIP,
+		OriginalURL:          base,
+		Tags:                 tags,
+		MetricRelabelConfigs: metricRelabelConfigs,
+	}
+}
+
+// decodeMonitorRelabelConfigs converts a CRD endpoint's raw relabelings/
+// metricRelabelings (decoded from unstructured YAML as
+// []map[string]interface{}, camelCase keys) into compiled *RelabelConfig
+// values, reusing encoding/json's case-insensitive field matching rather than
+// hand-rolling a second decoder for the same shape compileRelabelConfigs
+// already validates.
+func decodeMonitorRelabelConfigs(raw []map[string]interface{}) ([]*RelabelConfig, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not re-marshal relabel configs: %w", err)
+	}
+
+	var configs []*RelabelConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("could not decode relabel configs: %w", err)
+	}
+
+	if err := compileRelabelConfigs(configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// resolvePodPort resolves a PodMonitor endpoint's port (by name or number)
+// against the pod's own container ports, falling back to the raw value only
+// if it's already numeric and no named match is found.
+func resolvePodPort(pod *corev1.Pod, ep monitorEndpoint) string {
+	if ep.Port != "" {
+		for _, container := range pod.Spec.Containers {
+			for _, cp := range container.Ports {
+				if cp.Name == ep.Port {
+					return strconv.Itoa(int(cp.ContainerPort))
+				}
+			}
+		}
+	}
+	if ep.TargetPort.intVal != 0 {
+		return strconv.Itoa(int(ep.TargetPort.intVal))
+	}
+	if ep.TargetPort.strVal != "" {
+		for _, container := range pod.Spec.Containers {
+			for _, cp := range container.Ports {
+				if cp.Name == ep.TargetPort.strVal {
+					return strconv.Itoa(int(cp.ContainerPort))
+				}
+			}
+		}
+	}
+	if ep.Port != "" {
+		if _, err := strconv.Atoi(ep.Port); err == nil {
+			return ep.Port
+		}
+	}
+	return ""
+}
+
+// resolveServicePort resolves a ServiceMonitor endpoint's port against the
+// Service's own ports, as recorded on the joined Endpoints subset, since a
+// ServiceMonitor's endpoints[].port names the Service's port
+// (ServicePort.Name/Port) - the backing pod's container ports only share
+// that name by convention, not by guarantee.
+func resolveServicePort(ports []corev1.EndpointPort, ep monitorEndpoint) string {
+	for _, port := range ports {
+		if ep.Port != "" && port.Name == ep.Port {
+			return strconv.Itoa(int(port.Port))
+		}
+		if ep.TargetPort.intVal != 0 && port.Port == ep.TargetPort.intVal {
+			return strconv.Itoa(int(port.Port))
+		}
+		if ep.TargetPort.strVal != "" && port.Name == ep.TargetPort.strVal {
+			return strconv.Itoa(int(port.Port))
+		}
+	}
+	if ep.Port != "" {
+		if _, err := strconv.Atoi(ep.Port); err == nil {
+			return ep.Port
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func podWithContainerPort(name string, port int32) *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Ports: []corev1.ContainerPort{{Name: name, ContainerPort: port}}},
+			},
+		},
+	}
+}
+
+func TestResolvePodPort(t *testing.T) {
+	pod := podWithContainerPort("http-metrics", 9102)
+
+	tests := []struct {
+		name string
+		ep   monitorEndpoint
+		want string
+	}{
+		{"named port matches container port", monitorEndpoint{Port: "http-metrics"}, "9102"},
+		{"numeric targetPort used directly", monitorEndpoint{TargetPort: intOrStringJSON{intVal: 8080}}, "8080"},
+		{"named targetPort matches container port", monitorEndpoint{TargetPort: intOrStringJSON{strVal: "http-metrics"}}, "9102"},
+		{"numeric port string falls back when unmatched", monitorEndpoint{Port: "9999"}, "9999"},
+		{"non-numeric unmatched port is dropped", monitorEndpoint{Port: "no-such-port"}, ""},
+		{"nothing configured", monitorEndpoint{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePodPort(pod, tt.ep); got != tt.want {
+				t.Errorf("resolvePodPort() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveServicePort(t *testing.T) {
+	ports := []corev1.EndpointPort{
+		{Name: "web", Port: 80},
+		{Name: "metrics", Port: 9102},
+	}
+
+	tests := []struct {
+		name string
+		ep   monitorEndpoint
+		want string
+	}{
+		{"named port matches service port", monitorEndpoint{Port: "metrics"}, "9102"},
+		{"numeric targetPort matches service port number", monitorEndpoint{TargetPort: intOrStringJSON{intVal: 80}}, "80"},
+		{"named targetPort matches service port", monitorEndpoint{TargetPort: intOrStringJSON{strVal: "web"}}, "80"},
+		{"unmatched name with a container-only port is not resolved", monitorEndpoint{Port: "http-metrics"}, ""},
+		{"numeric port string falls back when unmatched", monitorEndpoint{Port: "1234"}, "1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveServicePort(ports, tt.ep); got != tt.want {
+				t.Errorf("resolveServicePort() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
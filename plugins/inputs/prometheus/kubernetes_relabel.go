@@ -0,0 +1,226 @@
+package prometheus
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RelabelConfig mirrors Prometheus's relabel_config: it is applied either
+// against the tag set built at discovery time (registerPod, via
+// p.RelabelConfigs) or against sample tags after parsing (via
+// p.MetricRelabelConfigs), and is configured as repeated
+// `[[inputs.prometheus.relabel_config]]` / `[[inputs.prometheus.metric_relabel_config]]`
+// TOML tables.
+type RelabelConfig struct {
+	SourceLabels []string `toml:"source_labels"`
+	Separator    string   `toml:"separator"`
+	Regex        string   `toml:"regex"`
+	Modulus      uint64   `toml:"modulus"`
+	TargetLabel  string   `toml:"target_label"`
+	Replacement  string   `toml:"replacement"`
+	Action       string   `toml:"action"`
+
+	regex *regexp.Regexp
+}
+
+const defaultRelabelSeparator = ";"
+
+// compile parses and fully anchors the configured regex, and fills in
+// defaults the way Prometheus does (separator ";", action "replace",
+// replacement "$1"). target_label has no default and is not checked here;
+// replace, hashmod, lowercase and uppercase leave the tags unchanged when it
+// is empty.
+func (rc *RelabelConfig) compile() error {
+	if rc.Separator == "" {
+		rc.Separator = defaultRelabelSeparator
+	}
+	if rc.Action == "" {
+		rc.Action = "replace"
+	}
+	if rc.Replacement == "" && rc.Action != "keep" && rc.Action != "drop" && rc.Action != "keepequal" && rc.Action != "dropequal" && rc.Action != "hashmod" && rc.Action != "labeldrop" && rc.Action != "labelkeep" {
+		// Prometheus defaults replacement to "$1" regardless of action; this
+		// matters most for labelmap, whose usual form (e.g.
+		// regex = "__meta_kubernetes_pod_label_(.+)") relies entirely on the
+		// default to produce a non-empty new label name.
+		rc.Replacement = "$1"
+	}
+
+	regexStr := rc.Regex
+	if regexStr == "" {
+		regexStr = "(.*)"
+	}
+
+	compiled, err := regexp.Compile("^(?:" + regexStr + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %w", rc.Regex, err)
+	}
+	rc.regex = compiled
+	return nil
+}
+
+// compileRelabelConfigs compiles every config in the slice, returning the
+// first error encountered so a bad config is rejected at plugin Init rather
+// than at scrape/discovery time.
+func compileRelabelConfigs(configs []*RelabelConfig) error {
+	for _, rc := range configs {
+		if err := rc.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRelabelConfigs runs tags through configs in order the way Prometheus's
+// relabeler does, returning the (possibly rewritten) tag set and whether the
+// target/series should be kept. Processing stops as soon as a drop/keep
+// decision discards the target.
+func applyRelabelConfigs(configs []*RelabelConfig, tags map[string]string) (map[string]string, bool) {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+
+	for _, rc := range configs {
+		var keep bool
+		out, keep = rc.apply(out)
+		if !keep {
+			return out, false
+		}
+	}
+	return out, true
+}
+
+func (rc *RelabelConfig) apply(tags map[string]string) (map[string]string, bool) {
+	switch rc.Action {
+	case "labeldrop":
+		return rc.applyLabelFilter(tags, false), true
+	case "labelkeep":
+		return rc.applyLabelFilter(tags, true), true
+	case "labelmap":
+		return rc.applyLabelMap(tags), true
+	}
+
+	value := rc.sourceValue(tags)
+
+	switch rc.Action {
+	case "keep":
+		return tags, rc.regex.MatchString(value)
+	case "drop":
+		return tags, !rc.regex.MatchString(value)
+	case "keepequal":
+		return tags, value == rc.Replacement
+	case "dropequal":
+		return tags, value != rc.Replacement
+	case "hashmod":
+		return rc.applyHashmod(tags, value), true
+	case "lowercase":
+		return rc.applyCase(tags, strings.ToLower), true
+	case "uppercase":
+		return rc.applyCase(tags, strings.ToUpper), true
+	default: // "replace"
+		return rc.applyReplace(tags, value), true
+	}
+}
+
+func (rc *RelabelConfig) sourceValue(tags map[string]string) string {
+	values := make([]string, len(rc.SourceLabels))
+	for i, label := range rc.SourceLabels {
+		values[i] = tags[label]
+	}
+	return strings.Join(values, rc.Separator)
+}
+
+func (rc *RelabelConfig) applyReplace(tags map[string]string, value string) map[string]string {
+	if rc.TargetLabel == "" {
+		return tags
+	}
+	match := rc.regex.FindStringSubmatchIndex(value)
+	if match == nil {
+		return tags
+	}
+	replacement := string(rc.regex.ExpandString(nil, rc.Replacement, value, match))
+	if replacement == "" {
+		delete(tags, rc.TargetLabel)
+		return tags
+	}
+	tags[rc.TargetLabel] = replacement
+	return tags
+}
+
+func (rc *RelabelConfig) applyCase(tags map[string]string, convert func(string) string) map[string]string {
+	if rc.TargetLabel == "" {
+		return tags
+	}
+	tags[rc.TargetLabel] = convert(rc.sourceValue(tags))
+	return tags
+}
+
+func (rc *RelabelConfig) applyHashmod(tags map[string]string, value string) map[string]string {
+	if rc.TargetLabel == "" || rc.Modulus == 0 {
+		return tags
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(value))
+	tags[rc.TargetLabel] = fmt.Sprintf("%d", h.Sum64()%rc.Modulus)
+	return tags
+}
+
+func (rc *RelabelConfig) applyLabelFilter(tags map[string]string, keep bool) map[string]string {
+	for k := range tags {
+		matches := rc.regex.MatchString(k)
+		if matches != keep {
+			delete(tags, k)
+		}
+	}
+	return tags
+}
+
+func (rc *RelabelConfig) applyLabelMap(tags map[string]string) map[string]string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		match := rc.regex.FindStringSubmatchIndex(k)
+		if match == nil {
+			continue
+		}
+		newKey := string(rc.regex.ExpandString(nil, rc.Replacement, k, match))
+		if newKey != "" {
+			tags[newKey] = tags[k]
+		}
+	}
+	return tags
+}
+
+// relabelDiscoveryTags applies p.RelabelConfigs to a newly discovered
+// target's tag set. A false return means the target was dropped and must not
+// be added to (or must be removed from) p.kubernetesPods.
+//
+// watchPodFromInformer/watchNodeLocalPods no longer hardcode the
+// prometheus.io/scrape=="true" gate themselves, so that relabel_config alone
+// can opt a pod in (e.g. via a keep rule on a different label). When no
+// relabel_config is configured at all, fall back to that annotation as the
+// implicit default rule, preserving the plugin's previous all-or-nothing
+// behavior for users who haven't adopted relabel_config.
+func (p *Prometheus) relabelDiscoveryTags(tags map[string]string) (map[string]string, bool) {
+	if len(p.RelabelConfigs) == 0 {
+		return tags, tags["prometheus.io/scrape"] == "true"
+	}
+	return applyRelabelConfigs(p.RelabelConfigs, tags)
+}
+
+// relabelMetricTags applies p.MetricRelabelConfigs to a parsed sample's tags,
+// called from the scrape/parse path after metrics are decoded. A false
+// return means the series must be dropped rather than emitted.
+func (p *Prometheus) relabelMetricTags(tags map[string]string) (map[string]string, bool) {
+	if len(p.MetricRelabelConfigs) == 0 {
+		return tags, true
+	}
+	return applyRelabelConfigs(p.MetricRelabelConfigs, tags)
+}
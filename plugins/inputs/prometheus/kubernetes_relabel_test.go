@@ -0,0 +1,120 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+)
+
+func compiledConfig(t *testing.T, rc *RelabelConfig) *RelabelConfig {
+	t.Helper()
+	if err := rc.compile(); err != nil {
+		t.Fatalf("compile() error = %v", err)
+	}
+	return rc
+}
+
+func TestApplyRelabelConfigsReplace(t *testing.T) {
+	rc := compiledConfig(t, &RelabelConfig{
+		SourceLabels: []string{"__meta_kubernetes_pod_label_app"},
+		TargetLabel:  "app",
+	})
+
+	tags, keep := applyRelabelConfigs([]*RelabelConfig{rc}, map[string]string{
+		"__meta_kubernetes_pod_label_app": "myapp",
+	})
+	if !keep {
+		t.Fatal("expected target to be kept")
+	}
+	if tags["app"] != "myapp" {
+		t.Errorf("tags[app] = %q, want %q", tags["app"], "myapp")
+	}
+}
+
+func TestApplyRelabelConfigsKeepDrop(t *testing.T) {
+	keepRC := compiledConfig(t, &RelabelConfig{
+		SourceLabels: []string{"env"},
+		Regex:        "prod",
+		Action:       "keep",
+	})
+	if _, keep := applyRelabelConfigs([]*RelabelConfig{keepRC}, map[string]string{"env": "prod"}); !keep {
+		t.Error("keep: expected matching value to be kept")
+	}
+	if _, keep := applyRelabelConfigs([]*RelabelConfig{keepRC}, map[string]string{"env": "staging"}); keep {
+		t.Error("keep: expected non-matching value to be dropped")
+	}
+
+	dropRC := compiledConfig(t, &RelabelConfig{
+		SourceLabels: []string{"env"},
+		Regex:        "staging",
+		Action:       "drop",
+	})
+	if _, keep := applyRelabelConfigs([]*RelabelConfig{dropRC}, map[string]string{"env": "staging"}); keep {
+		t.Error("drop: expected matching value to be dropped")
+	}
+	if _, keep := applyRelabelConfigs([]*RelabelConfig{dropRC}, map[string]string{"env": "prod"}); !keep {
+		t.Error("drop: expected non-matching value to be kept")
+	}
+}
+
+func TestApplyRelabelConfigsLabelmapDefaultsReplacement(t *testing.T) {
+	rc := compiledConfig(t, &RelabelConfig{
+		Regex:  "__meta_kubernetes_pod_label_(.+)",
+		Action: "labelmap",
+	})
+
+	tags, keep := applyRelabelConfigs([]*RelabelConfig{rc}, map[string]string{
+		"__meta_kubernetes_pod_label_app": "myapp",
+	})
+	if !keep {
+		t.Fatal("expected target to be kept")
+	}
+	if tags["app"] != "myapp" {
+		t.Errorf("tags[app] = %q, want %q (labelmap must default replacement to $1)", tags["app"], "myapp")
+	}
+}
+
+func TestApplyRelabelConfigsHashmod(t *testing.T) {
+	rc := compiledConfig(t, &RelabelConfig{
+		SourceLabels: []string{"__address__"},
+		TargetLabel:  "shard",
+		Modulus:      10,
+		Action:       "hashmod",
+	})
+
+	tags, keep := applyRelabelConfigs([]*RelabelConfig{rc}, map[string]string{"__address__": "10.0.0.1:9100"})
+	if !keep {
+		t.Fatal("expected target to be kept")
+	}
+	if tags["shard"] == "" {
+		t.Error("expected shard label to be set")
+	}
+}
+
+func TestParseExpositionSamples(t *testing.T) {
+	body := strings.NewReader(`
+# HELP go_goroutines Number of goroutines.
+# TYPE go_goroutines gauge
+go_goroutines 42
+http_requests_total{method="get",code="200"} 1027
+malformed_line_no_value
+`)
+
+	samples, err := parseExpositionSamples(body)
+	if err != nil {
+		t.Fatalf("parseExpositionSamples() error = %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2: %+v", len(samples), samples)
+	}
+
+	if samples[0].name != "go_goroutines" || samples[0].value != 42 {
+		t.Errorf("samples[0] = %+v, want name=go_goroutines value=42", samples[0])
+	}
+
+	if samples[1].name != "http_requests_total" || samples[1].value != 1027 {
+		t.Errorf("samples[1] = %+v, want name=http_requests_total value=1027", samples[1])
+	}
+	if samples[1].tags["method"] != "get" || samples[1].tags["code"] != "200" {
+		t.Errorf("samples[1].tags = %+v, want method=get code=200", samples[1].tags)
+	}
+}
@@ -0,0 +1,281 @@
+package prometheus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/influxdata/telegraf"
+)
+
+const defaultScrapeTimeout = 10 * time.Second
+const defaultScrapeInterval = 15 * time.Second
+
+// defaultMaxConcurrentScrapes mirrors the min(4*GOMAXPROCS, 64) rule of thumb
+// Prometheus itself uses for its scrape pool, bounding goroutine fan-out on
+// large clusters without needing to be hand-tuned per deployment.
+func defaultMaxConcurrentScrapes() int {
+	n := runtime.GOMAXPROCS(0) * 4
+	if n > 64 {
+		n = 64
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// startScrapeScheduler runs the back-pressured scrape pipeline: a single
+// enqueuer walks p.kubernetesPods on each target's own interval and pushes
+// the target's key onto a shared workqueue.RateLimitingInterface (the
+// same queue type watchPodFromInformer already uses), while a bounded pool of
+// workers dequeues and executes scrapes. Queue items carry no deadline; each
+// scrape's timeout starts when a worker dequeues it. Only started when
+// p.UseScrapeScheduler opts in; it replaces Gather's own synchronous
+// kubernetesPods scrape (see Gather), which produced thundering-herd
+// fan-out spikes and unbounded goroutines on large clusters.
+func (p *Prometheus) startScrapeScheduler(ctx context.Context) {
+	workers := p.MaxConcurrentScrapes
+	if workers <= 0 {
+		workers = defaultMaxConcurrentScrapes()
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.enqueueScrapeTargets(ctx, queue)
+	}()
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.runScrapeWorker(ctx, queue)
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+}
+
+// enqueueScrapeTargets ticks once a second and enqueues any target whose own
+// scrape interval (from the prometheus.io/interval tag, which comes from the
+// pod annotation of that name or from a relabel_config rule that sets it,
+// falling back to defaultScrapeInterval)
+// has elapsed since it was last queued.
+func (p *Prometheus) enqueueScrapeTargets(ctx context.Context, queue workqueue.RateLimitingInterface) {
+	nextDue := map[string]time.Time{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.lock.Lock()
+			targets := make(map[string]URLAndAddress, len(p.kubernetesPods))
+			for k, v := range p.kubernetesPods {
+				targets[k] = v
+			}
+			p.lock.Unlock()
+
+			for key, target := range targets {
+				due, ok := nextDue[key]
+				if ok && now.Before(due) {
+					continue
+				}
+				nextDue[key] = now.Add(scrapeInterval(target.Tags))
+				queue.Add(key)
+			}
+
+			for key := range nextDue {
+				if _, ok := targets[key]; !ok {
+					delete(nextDue, key)
+				}
+			}
+		}
+	}
+}
+
+func scrapeInterval(tags map[string]string) time.Duration {
+	if raw, ok := tags["prometheus.io/interval"]; ok {
+		if d, err := time.ParseDuration(raw); err == nil {
+			return d
+		}
+	}
+	return defaultScrapeInterval
+}
+
+func scrapeTimeout(tags map[string]string) time.Duration {
+	if raw, ok := tags["prometheus.io/scrape_timeout"]; ok {
+		if d, err := time.ParseDuration(raw); err == nil {
+			return d
+		}
+	}
+	return defaultScrapeTimeout
+}
+
+// runScrapeWorker is one of N worker-pool goroutines pulling targets off the
+// shared queue, scraping them with a per-target timeout, and rate-limited
+// re-queueing on failure so a flapping target's retries spread out instead of
+// hammering it every tick.
+func (p *Prometheus) runScrapeWorker(ctx context.Context, queue workqueue.RateLimitingInterface) {
+	for {
+		item, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+
+		key := item.(string)
+		p.scrapeOnce(ctx, queue, key)
+		queue.Done(item)
+	}
+}
+
+func (p *Prometheus) scrapeOnce(ctx context.Context, queue workqueue.RateLimitingInterface, key string) {
+	p.lock.Lock()
+	target, ok := p.kubernetesPods[key]
+	p.lock.Unlock()
+	if !ok {
+		queue.Forget(key)
+		return
+	}
+
+	timeout := scrapeTimeout(target.Tags)
+	scrapeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	up, samples, err := p.scrapeTarget(scrapeCtx, p.acc, target)
+	duration := time.Since(start)
+
+	if err != nil {
+		p.Log.Debugf("scrape of %s failed: %s", target.URL, err)
+		queue.AddRateLimited(key)
+	} else {
+		queue.Forget(key)
+	}
+
+	fields := map[string]interface{}{
+		"up":                      up,
+		"scrape_duration_seconds": duration.Seconds(),
+		"scrape_samples_scraped":  samples,
+	}
+	p.acc.AddFields("prometheus_scrape", fields, target.Tags)
+}
+
+// scrapeTarget performs the actual HTTP scrape, decodes each line of the
+// Prometheus text-exposition-format response into a sample, and runs every
+// sample's tags (target tags plus its own labels) through
+// metric_relabel_config before emitting it to acc. samples counts only the
+// series metric_relabel_config kept, matching Prometheus's own
+// scrape_samples_scraped semantics.
+func (p *Prometheus) scrapeTarget(ctx context.Context, acc telegraf.Accumulator, target URLAndAddress) (up bool, samples int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL.String(), nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not create scrape request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, 0, fmt.Errorf("scrape request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, 0, fmt.Errorf("scrape returned status %s", resp.Status)
+	}
+
+	exposed, err := parseExpositionSamples(resp.Body)
+	if err != nil {
+		return true, 0, fmt.Errorf("could not read scrape response: %w", err)
+	}
+
+	for _, sample := range exposed {
+		tags := make(map[string]string, len(target.Tags)+len(sample.tags)+1)
+		for k, v := range target.Tags {
+			tags[k] = v
+		}
+		for k, v := range sample.tags {
+			tags[k] = v
+		}
+		tags["__name__"] = sample.name
+
+		tags, keep := p.relabelMetricTags(tags)
+		if !keep {
+			continue
+		}
+		tags, keep = applyRelabelConfigs(target.MetricRelabelConfigs, tags)
+		if !keep {
+			continue
+		}
+		delete(tags, "__name__")
+
+		samples++
+		if acc != nil {
+			acc.AddFields(sample.name, map[string]interface{}{"value": sample.value}, tags)
+		}
+	}
+
+	return true, samples, nil
+}
+
+// expositionSample is one decoded line of a Prometheus text-exposition-format
+// body: a metric name, its labels, and its value.
+type expositionSample struct {
+	name  string
+	value float64
+	tags  map[string]string
+}
+
+var (
+	expositionLineRE  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})?\s+(\S+)`)
+	expositionLabelRE = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+)
+
+// parseExpositionSamples does a line-oriented parse of a Prometheus text
+// exposition body into (name, labels, value) tuples. This is deliberately not
+// a full OpenMetrics parser (no HELP/TYPE tracking, no exemplars); it exists
+// only to give metric_relabel_config something to match against.
+func parseExpositionSamples(body io.Reader) ([]expositionSample, error) {
+	var samples []expositionSample
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		match := expositionLineRE.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(match[4], 64)
+		if err != nil {
+			continue
+		}
+
+		tags := map[string]string{}
+		for _, labelMatch := range expositionLabelRE.FindAllStringSubmatch(match[3], -1) {
+			tags[labelMatch[1]] = labelMatch[2]
+		}
+
+		samples = append(samples, expositionSample{name: match[1], value: value, tags: tags})
+	}
+	return samples, scanner.Err()
+}
@@ -0,0 +1,330 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+const sampleConfig = `
+  ## An array of urls to scrape metrics from.
+  urls = ["http://localhost:9100/metrics"]
+
+  ## Scrape Kubernetes pods that carry the prometheus.io/scrape annotation.
+  # monitor_kubernetes_pods = false
+  ## Restrict pod discovery to a single namespace; empty means all namespaces.
+  # monitor_kubernetes_pods_namespace = ""
+  ## Label/field selectors applied to the pod discovery list/watch.
+  # kubernetes_label_selector = ""
+  # kubernetes_field_selector = ""
+  ## Scope of the pod discovery: "cluster" (default) watches every node's
+  ## pods; "node" restricts discovery to this instance's own node.
+  # pod_scrape_scope = "cluster"
+  ## How often (seconds) to poll for pods when the event-driven path used by
+  ## pod_scrape_scope = "node" isn't available.
+  # pod_scrape_interval = 60
+  ## This instance's node IP, used to build the default kubelet URL in node
+  ## scrape scope.
+  # node_ip = ""
+  ## Kubelet cAdvisor /pods client, used in node scrape scope when the
+  ## node-local pod informer isn't available. Defaults to
+  ## "https://<node_ip>:10250" with the in-cluster service account's CA and
+  ## a bearer token periodically re-read from kubelet_bearer_token_file.
+  # kubelet_url = ""
+  # kubelet_tls_ca = ""
+  # kubelet_tls_insecure_skip_verify = false
+  # kubelet_bearer_token_file = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+  ## This instance's node name, used to field-select its own pods in node
+  ## scrape scope. Defaults to the NODE_NAME downward-API env var.
+  # node_name = ""
+
+  ## Discover scrape targets from Kubernetes Endpoints/EndpointSlice objects
+  ## instead of watching Pods directly, mirroring Prometheus's
+  ## "role: endpoints" service discovery.
+  # monitor_kubernetes_endpoints = false
+
+  ## Discover scrape targets from monitoring.coreos.com/v1 PodMonitor and
+  ## ServiceMonitor custom resources instead of annotation-gated pod
+  ## discovery, so Telegraf can be a drop-in replacement for a
+  ## prometheus-operator scrape config. The only supported value is
+  ## "prometheus-operator".
+  # kubernetes_service_discovery = ""
+
+  ## Restrict cluster-scope discovery (PodMonitor/ServiceMonitor/Endpoints
+  ## watching) to a single leader-elected replica, so a DaemonSet of
+  ## Telegraf instances doesn't all duplicate the same cluster-wide target
+  ## set. Ignored in node scrape scope, which is already per-node.
+  # leader_election = false
+  # leader_election_lease_name = "telegraf-prometheus-input"
+  # leader_election_lease_namespace = "default"
+  # leader_election_lease_duration = "15s"
+  # leader_election_renew_deadline = "10s"
+  # leader_election_retry_period = "2s"
+
+  ## Scrape discovered Kubernetes targets on a back-pressured worker-pool
+  ## schedule, each on its own interval, instead of synchronously on every
+  ## Gather call. Recommended for large clusters; when enabled, Gather no
+  ## longer scrapes kubernetesPods itself, only the static urls list.
+  # use_scrape_scheduler = false
+  # max_concurrent_scrapes = 0 # 0 uses min(4*GOMAXPROCS, 64)
+
+  ## Relabel discovered targets' tags, and/or scraped samples' tags, using
+  ## Prometheus's relabel_config semantics. relabel_config runs against the
+  ## tag set built at discovery time (e.g. registerPod); metric_relabel_config
+  ## runs against each scraped sample's tags after parsing.
+  # [[inputs.prometheus.relabel_config]]
+  #   source_labels = ["__meta_kubernetes_pod_label_app"]
+  #   target_label = "app"
+  # [[inputs.prometheus.metric_relabel_config]]
+  #   source_labels = ["__name__"]
+  #   regex = "go_.*"
+  #   action = "drop"
+`
+
+// URLAndAddress couples a scrape target's fully resolved URL with the
+// original address it was discovered at and the tags to attach to whatever
+// it produces. Kubernetes discovery (registerPod, registerEndpointTarget,
+// the PodMonitor/ServiceMonitor watcher) all populate Prometheus.kubernetesPods
+// with these.
+type URLAndAddress struct {
+	URL         *url.URL
+	Address     string
+	OriginalURL *url.URL
+	Tags        map[string]string
+
+	// MetricRelabelConfigs holds per-target metric_relabel_config rules (for
+	// example decoded from a PodMonitor/ServiceMonitor endpoint's
+	// metricRelabelings), applied in addition to Prometheus.MetricRelabelConfigs.
+	MetricRelabelConfigs []*RelabelConfig
+}
+
+// Prometheus scrapes a statically configured list of URLs, a dynamically
+// discovered set of Kubernetes pods, or both.
+type Prometheus struct {
+	URLs []string `toml:"urls"`
+
+	KubeConfig string `toml:"kube_config"`
+
+	MonitorKubernetesPods   bool   `toml:"monitor_kubernetes_pods"`
+	PodNamespace            string `toml:"monitor_kubernetes_pods_namespace"`
+	KubernetesLabelSelector string `toml:"kubernetes_label_selector"`
+	KubernetesFieldSelector string `toml:"kubernetes_field_selector"`
+	PodScrapeScope          string `toml:"pod_scrape_scope"`
+	PodScrapeInterval       int    `toml:"pod_scrape_interval"`
+	NodeIP                  string `toml:"node_ip"`
+
+	// Kubelet* configure the cAdvisor /pods client used to discover node
+	// scrape scope's pods when the node-local pod informer isn't available
+	// (e.g. RBAC doesn't allow a field-selected pod list/watch).
+	KubeletURL                   string `toml:"kubelet_url"`
+	KubeletTLSCA                 string `toml:"kubelet_tls_ca"`
+	KubeletTLSInsecureSkipVerify bool   `toml:"kubelet_tls_insecure_skip_verify"`
+	KubeletBearerTokenFile       string `toml:"kubelet_bearer_token_file"`
+
+	// NodeName overrides the NODE_NAME downward-API env var a DaemonSet
+	// manifest conventionally sets, identifying which node's pods to
+	// field-select on in node scrape scope.
+	NodeName string `toml:"node_name"`
+
+	MonitorKubernetesEndpoints bool `toml:"monitor_kubernetes_endpoints"`
+
+	KubernetesServiceDiscovery string `toml:"kubernetes_service_discovery"`
+
+	RelabelConfigs       []*RelabelConfig `toml:"relabel_config"`
+	MetricRelabelConfigs []*RelabelConfig `toml:"metric_relabel_config"`
+
+	// LeaderElection restricts cluster-scope discovery (PodMonitor/
+	// ServiceMonitor/Endpoints watching) to a single replica at a time via a
+	// client-go Lease, so a DaemonSet of Telegraf instances doesn't all
+	// duplicate the same cluster-wide target set.
+	LeaderElection               bool            `toml:"leader_election"`
+	LeaderElectionLeaseName      string          `toml:"leader_election_lease_name"`
+	LeaderElectionLeaseNamespace string          `toml:"leader_election_lease_namespace"`
+	LeaderElectionLeaseDuration  config.Duration `toml:"leader_election_lease_duration"`
+	RenewDeadline                config.Duration `toml:"leader_election_renew_deadline"`
+	RetryPeriod                  config.Duration `toml:"leader_election_retry_period"`
+
+	// UseScrapeScheduler opts into scraping p.kubernetesPods on a back-pressured
+	// worker-pool schedule (see startScrapeScheduler) instead of synchronously
+	// on every Gather call. Gather skips its own kubernetesPods scrape while
+	// this is set, so a target is never scraped twice per interval.
+	UseScrapeScheduler   bool `toml:"use_scrape_scheduler"`
+	MaxConcurrentScrapes int  `toml:"max_concurrent_scrapes"`
+
+	Log telegraf.Logger `toml:"-"`
+
+	isNodeScrapeScope bool
+
+	podLabelSelector labels.Selector
+	podFieldSelector fields.Selector
+
+	// isLeading records whether this instance currently holds the leader
+	// election lease, guarded by lock like kubernetesPods; surfaced as the
+	// prometheus_leader_election metric so operators can alert on a
+	// DaemonSet with no leader (or, under a split-brain, more than one).
+	isLeading bool
+
+	lock           sync.Mutex
+	kubernetesPods map[string]URLAndAddress
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	acc    telegraf.Accumulator
+}
+
+func (*Prometheus) SampleConfig() string {
+	return sampleConfig
+}
+
+// AddressToURL returns a copy of u with its host replaced by address, keeping
+// the scheme, path, and port intact. Kubernetes discovery resolves a pod's
+// scrape URL from its annotations/status but still wants the ability to
+// substitute a different address (e.g. the pod's hostname) for the host
+// portion without disturbing the rest of the URL.
+func (*Prometheus) AddressToURL(u *url.URL, address string) *url.URL {
+	host := address
+	if _, port, err := net.SplitHostPort(u.Host); err == nil && port != "" {
+		host = net.JoinHostPort(address, port)
+	}
+
+	out := *u
+	out.Host = host
+	return &out
+}
+
+// Init parses the kubernetes_label_selector/kubernetes_field_selector
+// strings once at startup, rather than re-parsing them on every discovery
+// event, and derives isNodeScrapeScope from pod_scrape_scope.
+func (p *Prometheus) Init() error {
+	p.isNodeScrapeScope = p.PodScrapeScope == "node"
+
+	if p.KubernetesLabelSelector != "" {
+		selector, err := labels.Parse(p.KubernetesLabelSelector)
+		if err != nil {
+			return fmt.Errorf("error parsing kubernetes_label_selector %q: %w", p.KubernetesLabelSelector, err)
+		}
+		p.podLabelSelector = selector
+	}
+
+	if p.KubernetesFieldSelector != "" {
+		selector, err := fields.ParseSelector(p.KubernetesFieldSelector)
+		if err != nil {
+			return fmt.Errorf("error parsing kubernetes_field_selector %q: %w", p.KubernetesFieldSelector, err)
+		}
+		p.podFieldSelector = selector
+	}
+
+	if err := compileRelabelConfigs(p.RelabelConfigs); err != nil {
+		return fmt.Errorf("error compiling relabel_config: %w", err)
+	}
+	if err := compileRelabelConfigs(p.MetricRelabelConfigs); err != nil {
+		return fmt.Errorf("error compiling metric_relabel_config: %w", err)
+	}
+
+	return nil
+}
+
+// Start launches Kubernetes pod discovery, if configured, as a background
+// goroutine and returns immediately; Gather (or, with use_scrape_scheduler,
+// the worker-pool scheduler) is then responsible for scraping whatever
+// targets that discovery has populated into p.kubernetesPods.
+func (p *Prometheus) Start(acc telegraf.Accumulator) error {
+	p.acc = acc
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	if p.MonitorKubernetesPods || p.MonitorKubernetesEndpoints || p.KubernetesServiceDiscovery != "" {
+		if err := p.startK8s(p.ctx); err != nil {
+			p.cancel()
+			return fmt.Errorf("error starting kubernetes discovery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Prometheus) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+}
+
+// Gather scrapes the statically configured urls list plus, unless
+// UseScrapeScheduler is set, every target Kubernetes discovery has
+// registered in p.kubernetesPods.
+func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
+	if p.LeaderElection {
+		p.lock.Lock()
+		isLeading := p.isLeading
+		p.lock.Unlock()
+		acc.AddFields("prometheus_leader_election", map[string]interface{}{"is_leader": isLeading}, nil)
+	}
+
+	for _, u := range p.URLs {
+		target, err := url.Parse(u)
+		if err != nil {
+			acc.AddError(fmt.Errorf("error parsing url %q: %w", u, err))
+			continue
+		}
+		p.gatherTarget(acc, URLAndAddress{URL: target, Address: target.Hostname(), OriginalURL: target})
+	}
+
+	// When the worker-pool scheduler is running, it scrapes kubernetesPods
+	// itself; scraping it here too would double-scrape every target.
+	if p.UseScrapeScheduler {
+		return nil
+	}
+
+	p.lock.Lock()
+	targets := make(map[string]URLAndAddress, len(p.kubernetesPods))
+	for k, v := range p.kubernetesPods {
+		targets[k] = v
+	}
+	p.lock.Unlock()
+
+	for _, target := range targets {
+		p.gatherTarget(acc, target)
+	}
+
+	return nil
+}
+
+// gatherTarget performs one HTTP scrape of target (via the same scrapeTarget
+// helper the Kubernetes worker-pool scheduler uses) and records the outcome
+// as the up/scrape_duration_seconds/scrape_samples_scraped summary fields
+// Prometheus itself emits per target.
+func (p *Prometheus) gatherTarget(acc telegraf.Accumulator, target URLAndAddress) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultScrapeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	up, samples, err := p.scrapeTarget(ctx, acc, target)
+	duration := time.Since(start)
+
+	if err != nil {
+		p.Log.Debugf("scrape of %s failed: %s", target.URL, err)
+	}
+
+	acc.AddFields("prometheus_scrape", map[string]interface{}{
+		"up":                      up,
+		"scrape_duration_seconds": duration.Seconds(),
+		"scrape_samples_scraped":  samples,
+	}, target.Tags)
+}
+
+func init() {
+	inputs.Add("prometheus", func() telegraf.Input {
+		return &Prometheus{}
+	})
+}